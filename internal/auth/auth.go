@@ -0,0 +1,88 @@
+// Package auth implements pluggable authentication for ecr-proxy's
+// downstream (client-facing) HTTP endpoint, independent of the AWS
+// credentials ecr-proxy itself uses to talk to ECR upstream. The scheme is
+// selected by the AUTH config value, a URL-style spec:
+//
+//	AUTH=static://user:pass
+//	AUTH=htpasswd:///etc/ecr-proxy/htpasswd
+//	AUTH=mtls://?ca=/etc/ecr-proxy/ca.pem
+//	AUTH=bearer://?jwks_url=https://issuer/.well-known/jwks.json
+//
+// An empty AUTH disables downstream authentication entirely.
+package auth
+
+import (
+	"context"
+	gotls "crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Authenticator validates a downstream client request and reports the
+// identity it authenticated as.
+type Authenticator interface {
+	// Authenticate validates r's credentials, returning the authenticated
+	// subject (e.g. a username or certificate CommonName) on success. On
+	// failure it returns a non-nil error describing why.
+	Authenticate(r *http.Request) (subject string, err error)
+
+	// Challenge returns the WWW-Authenticate header value to send alongside
+	// a 401 response, per the Docker Registry v2 authentication spec. It
+	// returns "" when no challenge header is applicable.
+	Challenge() string
+}
+
+// TLSConfigurer is implemented by Authenticators that need to influence the
+// server's tls.Config, e.g. AUTH=mtls://... requiring a client certificate
+// at the handshake layer rather than from request headers.
+type TLSConfigurer interface {
+	ConfigureTLS(cfg *gotls.Config)
+}
+
+// New builds the Authenticator described by spec, an AUTH config value of
+// the form "scheme://...". An empty spec disables authentication, returning
+// a nil Authenticator and nil error.
+func New(spec string) (Authenticator, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	idx := strings.Index(spec, "://")
+	if idx < 0 {
+		return nil, fmt.Errorf("invalid AUTH spec %q: expected scheme://...", spec)
+	}
+	scheme, rest := spec[:idx], spec[idx+3:]
+
+	switch scheme {
+	case "static":
+		return newStaticAuth(rest)
+	case "htpasswd":
+		return newHtpasswdAuth(rest)
+	case "mtls":
+		return newMTLSAuth(rest)
+	case "bearer":
+		return newBearerAuth(rest)
+	default:
+		return nil, fmt.Errorf("invalid AUTH spec %q: unknown scheme %q", spec, scheme)
+	}
+}
+
+type contextKey int
+
+const subjectKey contextKey = 0
+
+// WithSubject returns a copy of ctx recording the identity an Authenticator
+// authenticated the request as.
+func WithSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey, subject)
+}
+
+// SubjectFromContext returns the subject recorded by WithSubject, or "" if
+// the request wasn't authenticated (no AUTH configured, or AUTH succeeded
+// with an empty subject).
+func SubjectFromContext(ctx context.Context) string {
+	subject, _ := ctx.Value(subjectKey).(string)
+	return subject
+}
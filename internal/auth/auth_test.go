@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNew_EmptySpecDisablesAuth(t *testing.T) {
+	a, err := New("")
+	if err != nil {
+		t.Fatalf("New(\"\") returned error: %v", err)
+	}
+	if a != nil {
+		t.Fatalf("New(\"\") = %v, want nil", a)
+	}
+}
+
+func TestNew_UnknownScheme(t *testing.T) {
+	if _, err := New("carrierpigeon://user:pass"); err == nil {
+		t.Fatal("New() with unknown scheme: expected error, got nil")
+	}
+}
+
+func TestNew_MissingSeparator(t *testing.T) {
+	if _, err := New("static-user-pass"); err == nil {
+		t.Fatal("New() without \"://\": expected error, got nil")
+	}
+}
+
+func TestStaticAuth_Authenticate(t *testing.T) {
+	a, err := New("static://alice:s3cret")
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		user, pass string
+		noAuth     bool
+		wantErr    bool
+	}{
+		{name: "correct credentials", user: "alice", pass: "s3cret"},
+		{name: "wrong password", user: "alice", pass: "wrong", wantErr: true},
+		{name: "wrong user", user: "bob", pass: "s3cret", wantErr: true},
+		{name: "missing credentials", noAuth: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+			if !tt.noAuth {
+				r.SetBasicAuth(tt.user, tt.pass)
+			}
+
+			subject, err := a.Authenticate(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && subject != tt.user {
+				t.Errorf("Authenticate() subject = %q, want %q", subject, tt.user)
+			}
+		})
+	}
+}
+
+func TestNew_StaticMissingPassword(t *testing.T) {
+	if _, err := New("static://alice"); err == nil {
+		t.Fatal("New(\"static://alice\"): expected error, got nil")
+	}
+}
+
+func TestHtpasswdAuth_Authenticate(t *testing.T) {
+	// {SHA}5en6G6MezRroT3XKqkdPOmY/BfQ= is the SHA htpasswd hash for "secret".
+	dir := t.TempDir()
+	path := filepath.Join(dir, "htpasswd")
+	contents := "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n# comment\n\nbob:notavalidhash\n"
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("failed to write htpasswd fixture: %v", err)
+	}
+
+	a, err := New("htpasswd://" + path)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		user, pass string
+		wantErr    bool
+	}{
+		{name: "correct SHA password", user: "alice", pass: "secret"},
+		{name: "wrong password", user: "alice", pass: "wrong", wantErr: true},
+		{name: "unknown user", user: "carol", pass: "secret", wantErr: true},
+		{name: "unsupported hash format", user: "bob", pass: "notavalidhash", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+			r.SetBasicAuth(tt.user, tt.pass)
+
+			subject, err := a.Authenticate(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && subject != tt.user {
+				t.Errorf("Authenticate() subject = %q, want %q", subject, tt.user)
+			}
+		})
+	}
+}
+
+func TestNew_HtpasswdMissingFile(t *testing.T) {
+	if _, err := New("htpasswd:///does/not/exist"); err == nil {
+		t.Fatal("New() with missing htpasswd file: expected error, got nil")
+	}
+}
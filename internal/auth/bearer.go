@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval bounds how long bearerAuth caches fetched signing
+// keys before refetching the JWKS document.
+const jwksRefreshInterval = 1 * time.Hour
+
+// unknownKidCooldown rate-limits JWKS refreshes triggered by a token whose
+// kid isn't in the cache. Without it, a client could force an outbound
+// request to jwks_url on every single request just by varying kid.
+const unknownKidCooldown = 30 * time.Second
+
+// bearerAuth authenticates requests bearing an RS256-signed JWT, verified
+// against keys published at a JWKS endpoint. Configured via
+// AUTH=bearer://?jwks_url=...&realm=...&service=....
+type bearerAuth struct {
+	jwksURL string
+	realm   string
+	service string
+
+	mu                 sync.RWMutex
+	keys               map[string]*rsa.PublicKey
+	fetchedAt          time.Time
+	lastUnknownRefresh time.Time
+}
+
+func newBearerAuth(rest string) (*bearerAuth, error) {
+	query, err := url.ParseQuery(strings.TrimPrefix(rest, "?"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bearer AUTH spec %q: %w", rest, err)
+	}
+
+	jwksURL := query.Get("jwks_url")
+	if jwksURL == "" {
+		return nil, fmt.Errorf("invalid bearer AUTH spec %q: expected bearer://?jwks_url=...", rest)
+	}
+
+	a := &bearerAuth{
+		jwksURL: jwksURL,
+		realm:   query.Get("realm"),
+		service: query.Get("service"),
+	}
+	if a.realm == "" {
+		a.realm = jwksURL
+	}
+	if a.service == "" {
+		a.service = "ecr-proxy"
+	}
+
+	if err := a.refreshKeys(); err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS from %s: %w", jwksURL, err)
+	}
+	return a, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwksDoc struct {
+	Keys []jwk `json:"keys"`
+}
+
+func (a *bearerAuth) refreshKeys() error {
+	resp, err := http.Get(a.jwksURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d fetching JWKS", resp.StatusCode)
+	}
+
+	var doc jwksDoc
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" || k.Kid == "" {
+			continue
+		}
+		pub, err := rsaPublicKeyFromJWK(k)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	a.mu.Lock()
+	a.keys = keys
+	a.fetchedAt = time.Now()
+	a.mu.Unlock()
+	return nil
+}
+
+func rsaPublicKeyFromJWK(k jwk) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWK modulus: %w", err)
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWK exponent: %w", err)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(new(big.Int).SetBytes(eBytes).Int64()),
+	}, nil
+}
+
+// keyFor returns the signing key for kid, transparently refreshing the
+// JWKS cache when it's missing the key or has gone stale. A refresh
+// triggered by an unrecognized kid is rate-limited by unknownKidCooldown,
+// since kid is attacker-controlled and would otherwise let a client force
+// an outbound JWKS fetch on every request just by varying it.
+func (a *bearerAuth) keyFor(kid string) (*rsa.PublicKey, error) {
+	a.mu.RLock()
+	key, ok := a.keys[kid]
+	stale := time.Since(a.fetchedAt) > jwksRefreshInterval
+	a.mu.RUnlock()
+	if ok && !stale {
+		return key, nil
+	}
+
+	if !ok {
+		a.mu.RLock()
+		cooling := time.Since(a.lastUnknownRefresh) < unknownKidCooldown
+		a.mu.RUnlock()
+		if cooling {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		a.mu.Lock()
+		a.lastUnknownRefresh = time.Now()
+		a.mu.Unlock()
+	}
+
+	if err := a.refreshKeys(); err != nil {
+		if ok {
+			// Serve the stale key rather than fail outright on a
+			// transient JWKS fetch error.
+			return key, nil
+		}
+		return nil, err
+	}
+
+	a.mu.RLock()
+	key, ok = a.keys[kid]
+	a.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Sub string `json:"sub"`
+	Exp int64  `json:"exp"`
+}
+
+// Authenticate verifies an RS256-signed bearer JWT against the cached JWKS
+// keys and returns its "sub" claim as the authenticated subject. A token
+// with no "exp" claim is intentionally treated as never-expiring rather
+// than rejected - operators who want expiry enforced must issue tokens
+// that set it.
+func (a *bearerAuth) Authenticate(r *http.Request) (string, error) {
+	authz := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authz, "Bearer ") {
+		return "", fmt.Errorf("missing bearer token")
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(authz, "Bearer "))
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed JWT")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("malformed JWT header: %w", err)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return "", fmt.Errorf("malformed JWT header: %w", err)
+	}
+	if header.Alg != "RS256" {
+		return "", fmt.Errorf("unsupported JWT algorithm %q", header.Alg)
+	}
+
+	key, err := a.keyFor(header.Kid)
+	if err != nil {
+		return "", fmt.Errorf("no signing key for %q: %w", header.Kid, err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed JWT signature: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return "", fmt.Errorf("invalid JWT signature: %w", err)
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed JWT claims: %w", err)
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return "", fmt.Errorf("malformed JWT claims: %w", err)
+	}
+	if claims.Exp != 0 && time.Now().Unix() > claims.Exp {
+		return "", fmt.Errorf("JWT has expired")
+	}
+
+	return claims.Sub, nil
+}
+
+func (a *bearerAuth) Challenge() string {
+	return fmt.Sprintf(`Bearer realm="%s",service="%s",scope="repository:*:pull"`, a.realm, a.service)
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBearerAuth_UnknownKidRefreshIsRateLimited(t *testing.T) {
+	var fetches int32
+	srv := httptest.NewServer(newJWKSHandler(&fetches))
+	defer srv.Close()
+
+	a, err := newBearerAuth("?jwks_url=" + srv.URL)
+	if err != nil {
+		t.Fatalf("newBearerAuth: %v", err)
+	}
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Fatalf("fetches after construction = %d, want 1", got)
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := a.keyFor("no-such-kid"); err == nil {
+			t.Fatal("keyFor() for an unknown kid: expected error, got nil")
+		}
+	}
+
+	if got := atomic.LoadInt32(&fetches); got != 2 {
+		t.Fatalf("fetches after 5 unknown-kid lookups = %d, want 2 (construction + one throttled refresh)", got)
+	}
+}
+
+func TestBearerAuth_StaleKnownKeyStillRefreshes(t *testing.T) {
+	var fetches int32
+	srv := httptest.NewServer(newJWKSHandler(&fetches))
+	defer srv.Close()
+
+	a, err := newBearerAuth("?jwks_url=" + srv.URL)
+	if err != nil {
+		t.Fatalf("newBearerAuth: %v", err)
+	}
+
+	// Seed a known key, keep the unknown-kid cooldown active, and force the
+	// cache to look stale without waiting out jwksRefreshInterval, so only
+	// staleness can trigger the refresh below.
+	a.mu.Lock()
+	a.keys["known-kid"] = &rsa.PublicKey{N: big.NewInt(1), E: 65537}
+	a.lastUnknownRefresh = time.Now()
+	a.fetchedAt = a.fetchedAt.Add(-2 * jwksRefreshInterval)
+	a.mu.Unlock()
+
+	// The refreshed JWKS document no longer lists the key.
+	if _, err := a.keyFor("known-kid"); err == nil {
+		t.Fatal("keyFor() for a kid dropped from the refreshed JWKS: expected error, got nil")
+	}
+	if got := atomic.LoadInt32(&fetches); got != 2 {
+		t.Fatalf("fetches after staleness-triggered lookup = %d, want 2", got)
+	}
+}
+
+func newJWKSHandler(fetches *int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(fetches, 1)
+		w.Write([]byte(`{"keys":[]}`))
+	})
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// htpasswdAuth authenticates requests against an Apache-style htpasswd
+// file, configured via AUTH=htpasswd:///path/to/htpasswd. It supports
+// bcrypt ($2a$/$2b$/$2y$) and SHA ({SHA}) hashes, and reloads the file
+// whenever its mtime changes so credentials can be rotated without a
+// restart.
+type htpasswdAuth struct {
+	path string
+
+	mu      sync.RWMutex
+	modTime time.Time
+	creds   map[string]string
+}
+
+func newHtpasswdAuth(path string) (*htpasswdAuth, error) {
+	if path == "" {
+		return nil, fmt.Errorf("invalid htpasswd AUTH spec: expected htpasswd:///path/to/htpasswd")
+	}
+	a := &htpasswdAuth{path: path}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *htpasswdAuth) reload() error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to open htpasswd file %s: %w", a.path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat htpasswd file %s: %w", a.path, err)
+	}
+
+	creds := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		creds[parts[0]] = parts[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read htpasswd file %s: %w", a.path, err)
+	}
+
+	a.mu.Lock()
+	a.creds = creds
+	a.modTime = info.ModTime()
+	a.mu.Unlock()
+	return nil
+}
+
+// maybeReload reloads the htpasswd file if it has changed on disk since it
+// was last read. A failed reload is swallowed and the previously loaded
+// credentials keep serving - a transient stat/read error shouldn't lock
+// every client out.
+func (a *htpasswdAuth) maybeReload() {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return
+	}
+	a.mu.RLock()
+	changed := !info.ModTime().Equal(a.modTime)
+	a.mu.RUnlock()
+	if changed {
+		_ = a.reload()
+	}
+}
+
+func (a *htpasswdAuth) Authenticate(r *http.Request) (string, error) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return "", fmt.Errorf("missing basic auth credentials")
+	}
+
+	a.maybeReload()
+
+	a.mu.RLock()
+	hash, ok := a.creds[user]
+	a.mu.RUnlock()
+	if !ok || !verifyHtpasswd(hash, pass) {
+		return "", fmt.Errorf("invalid credentials")
+	}
+	return user, nil
+}
+
+func (a *htpasswdAuth) Challenge() string {
+	return `Basic realm="ecr-proxy"`
+}
+
+// verifyHtpasswd checks pass against an htpasswd hash, supporting bcrypt
+// ($2a$/$2b$/$2y$ prefixes) and SHA ({SHA} + base64 SHA-1) formats.
+func verifyHtpasswd(hash, pass string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(pass))
+		want := base64.StdEncoding.EncodeToString(sum[:])
+		return strings.TrimPrefix(hash, "{SHA}") == want
+	default:
+		return false
+	}
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// staticAuth authenticates every request against a single fixed
+// username/password pair, configured via AUTH=static://user:pass.
+type staticAuth struct {
+	user string
+	pass string
+}
+
+// newStaticAuth parses the "user:pass" remainder of a static:// AUTH spec.
+// net/url.Parse can't be used here: without an "@" separator it parses
+// "user:pass" as a host:port authority and rejects "pass" as an invalid
+// port.
+func newStaticAuth(rest string) (*staticAuth, error) {
+	parts := strings.SplitN(rest, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid static AUTH spec %q: expected static://user:pass", rest)
+	}
+	return &staticAuth{user: parts[0], pass: parts[1]}, nil
+}
+
+func (a *staticAuth) Authenticate(r *http.Request) (string, error) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return "", fmt.Errorf("missing basic auth credentials")
+	}
+
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(a.user)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(a.pass)) == 1
+	if !userOK || !passOK {
+		return "", fmt.Errorf("invalid credentials")
+	}
+	return user, nil
+}
+
+func (a *staticAuth) Challenge() string {
+	return `Basic realm="ecr-proxy"`
+}
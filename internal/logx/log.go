@@ -7,6 +7,9 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/giuliocalzolari/ecr-proxy/internal/auth"
+	"github.com/giuliocalzolari/ecr-proxy/internal/proxyproto"
 )
 
 type LogEntry struct {
@@ -16,23 +19,29 @@ type LogEntry struct {
 	Time       string `json:"time"`
 	Msg        string `json:"msg"`
 	Path       string `json:"path"`
+	Subject    string `json:"subject,omitempty"`
 }
 
 func Print(r *http.Request, msg string) {
-	// Extract client IP with X-Forwarded-For fallback
-	clientIP := r.Header.Get("X-Forwarded-For")
-	if clientIP != "" {
-		// Take the first IP in the list and validate
-		clientIP = strings.Split(clientIP, ",")[0]
-		clientIP = strings.TrimSpace(clientIP)
-		if net.ParseIP(clientIP) == nil {
-			clientIP = ""
+	// Only honor X-Forwarded-For/X-Real-IP when the direct TCP peer is a
+	// trusted proxy (see TRUSTED_PROXIES) - otherwise they're spoofable by
+	// anyone who can reach the listener and must be ignored.
+	var clientIP string
+	if proxyproto.TrustedPeerFromContext(r.Context()) {
+		clientIP = r.Header.Get("X-Forwarded-For")
+		if clientIP != "" {
+			// Take the first IP in the list and validate
+			clientIP = strings.Split(clientIP, ",")[0]
+			clientIP = strings.TrimSpace(clientIP)
+			if net.ParseIP(clientIP) == nil {
+				clientIP = ""
+			}
 		}
-	}
-	if clientIP == "" {
-		clientIP = r.Header.Get("X-Real-IP")
-		if clientIP != "" && net.ParseIP(clientIP) == nil {
-			clientIP = ""
+		if clientIP == "" {
+			clientIP = r.Header.Get("X-Real-IP")
+			if clientIP != "" && net.ParseIP(clientIP) == nil {
+				clientIP = ""
+			}
 		}
 	}
 
@@ -64,6 +73,7 @@ func Print(r *http.Request, msg string) {
 		Time:       time.Now().Format(time.RFC3339),
 		Msg:        sanitizedMsg,
 		Path:       r.URL.Path,
+		Subject:    auth.SubjectFromContext(r.Context()),
 	}
 
 	logData, err := json.Marshal(logEntry)
@@ -73,4 +83,4 @@ func Print(r *http.Request, msg string) {
 	}
 
 	fmt.Printf("%s\n", logData)
-}
\ No newline at end of file
+}
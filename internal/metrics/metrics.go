@@ -0,0 +1,95 @@
+// Package metrics defines ecr-proxy's Prometheus metrics and exposes the
+// /metrics HTTP handler that serves them.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// ProxyRequestsTotal counts proxied requests by method, response
+	// status, and the resolved account/region, which (unlike the
+	// client-controlled repo path) is bounded by AWS_ACCOUNTS.
+	ProxyRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecr_proxy_requests_total",
+		Help: "Total number of requests proxied to ECR.",
+	}, []string{"method", "status", "account", "region"})
+
+	// ProxyRequestDuration observes end-to-end latency of proxied requests.
+	ProxyRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ecr_proxy_request_duration_seconds",
+		Help:    "Latency of requests proxied to ECR, in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "status"})
+
+	// TokenRefreshTotal counts ECR token refresh attempts by result.
+	TokenRefreshTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecr_proxy_token_refresh_total",
+		Help: "Total number of ECR token refresh attempts, labelled by result (success or failure).",
+	}, []string{"result"})
+
+	// TokenRefreshDuration observes how long ECR token refreshes take.
+	TokenRefreshDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ecr_proxy_token_refresh_duration_seconds",
+		Help:    "Duration of ECR GetAuthorizationToken calls, in seconds.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// TokenExpirySeconds reports how many seconds remained until a managed
+	// token expires, labelled by account/region. It is set on each
+	// successful refresh and is not updated in between.
+	TokenExpirySeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ecr_proxy_token_expiry_seconds",
+		Help: "Seconds until the managed ECR token expires, as of its last successful refresh.",
+	}, []string{"account", "region"})
+
+	// IPWhitelistDecisions counts IsIPAllowed decisions by outcome and the
+	// CIDR entry that matched (or "none" when denied).
+	IPWhitelistDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecr_proxy_ip_whitelist_decisions_total",
+		Help: "IP whitelist allow/deny decisions, labelled by decision and matched CIDR.",
+	}, []string{"decision", "cidr"})
+
+	// BuildInfo is a constant 1 gauge labelled with version/commit, used to
+	// join build metadata onto other queries.
+	BuildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ecr_proxy_build_info",
+		Help: "Build information for the running binary. Always 1.",
+	}, []string{"version", "commit"})
+
+	// UpstreamRequestsTotal counts requests made to the upstream ECR
+	// registry, labelled by method and response status code.
+	UpstreamRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecr_proxy_upstream_requests_total",
+		Help: "Total requests made to the upstream ECR registry, labelled by method and status code.",
+	}, []string{"code", "method"})
+
+	// UpstreamRequestDuration observes latency calling the upstream ECR
+	// registry.
+	UpstreamRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ecr_proxy_upstream_request_duration_seconds",
+		Help:    "Latency observed calling the upstream ECR registry, in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+// WrapTransport instruments rt (or http.DefaultTransport, if rt is nil)
+// with UpstreamRequestsTotal and UpstreamRequestDuration, so a
+// httputil.ReverseProxy's upstream calls to ECR are counted and timed.
+func WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return promhttp.InstrumentRoundTripperDuration(UpstreamRequestDuration,
+		promhttp.InstrumentRoundTripperCounter(UpstreamRequestsTotal, rt))
+}
+
+// Handler returns the HTTP handler that serves /metrics in the Prometheus
+// text exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,323 @@
+// Package proxyproto implements PROXY protocol v1/v2 parsing at the
+// net.Listener layer, gated by a trusted-proxies allowlist. It lets
+// ecr-proxy recover the real client address when it sits behind an AWS
+// NLB, HAProxy, or similar intermediary that prepends a PROXY header.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headerReadTimeout bounds how long Accept will block reading a PROXY
+// header off a trusted peer before giving up. Without it, a trusted peer
+// that opens a connection and never writes a byte would hang Accept
+// indefinitely, stalling every other client on the listener.
+const headerReadTimeout = 5 * time.Second
+
+// Mode controls whether a Listener will parse PROXY protocol headers.
+type Mode string
+
+const (
+	// ModeOff disables PROXY protocol parsing entirely.
+	ModeOff Mode = "off"
+	// ModeOptional parses a PROXY header when present but accepts
+	// connections that don't send one, falling back to the TCP peer.
+	ModeOptional Mode = "optional"
+	// ModeRequired rejects connections from untrusted peers, and from
+	// trusted peers that don't send a valid PROXY header.
+	ModeRequired Mode = "required"
+)
+
+// ParseMode validates and normalizes a PROXY_PROTOCOL config value.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(strings.ToLower(strings.TrimSpace(s))) {
+	case "", ModeOff:
+		return ModeOff, nil
+	case ModeOptional:
+		return ModeOptional, nil
+	case ModeRequired:
+		return ModeRequired, nil
+	default:
+		return "", fmt.Errorf("invalid PROXY_PROTOCOL mode %q: must be off, optional, or required", s)
+	}
+}
+
+var v2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// Listener wraps a net.Listener, parsing a PROXY protocol header off the
+// front of each accepted connection when the peer is in the trusted set.
+type Listener struct {
+	net.Listener
+	mode    Mode
+	trusted []*net.IPNet
+}
+
+// NewListener wraps inner with PROXY protocol support. trustedCIDRs is a
+// comma-separated list of IPs or CIDR ranges; only peers within it are
+// permitted to send a PROXY header.
+func NewListener(inner net.Listener, mode Mode, trustedCIDRs string) (*Listener, error) {
+	nets, err := parseTrustedProxies(trustedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{Listener: inner, mode: mode, trusted: nets}, nil
+}
+
+// Accept wraps each accepted connection, parsing the PROXY header, if any,
+// before returning it to the caller. This must happen synchronously here
+// rather than lazily on first Read: net/http's connection handler reads
+// conn.RemoteAddr() before issuing any Read, so a lazily-parsed address
+// would never reach http.Request.RemoteAddr.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		pc := &Conn{Conn: c, mode: l.mode, trustedPeer: l.isTrusted(c.RemoteAddr())}
+		pc.parseHeader()
+		if pc.err != nil {
+			// Reject only this connection: returning the error would make
+			// http.Server.Serve stop accepting altogether.
+			pc.Conn.Close()
+			continue
+		}
+		return pc, nil
+	}
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range l.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTrustedProxies(cidrList string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(cidrList, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: %w", entry, err)
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+// Conn wraps a net.Conn, transparently stripping a leading PROXY protocol
+// header and reporting the embedded client address via RemoteAddr.
+type Conn struct {
+	net.Conn
+	mode        Mode
+	trustedPeer bool
+
+	br      *bufio.Reader
+	srcAddr net.Addr
+	err     error
+}
+
+// Trusted reports whether the direct TCP peer is in the trusted-proxies
+// allowlist, regardless of whether it actually sent a PROXY header.
+func (c *Conn) Trusted() bool {
+	return c.trustedPeer
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.br != nil {
+		return c.br.Read(b)
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *Conn) parseHeader() {
+	if c.mode == ModeOff || !c.trustedPeer {
+		if c.mode == ModeRequired && !c.trustedPeer {
+			c.err = fmt.Errorf("proxyproto: required mode but peer %s is not in TRUSTED_PROXIES", c.Conn.RemoteAddr())
+		}
+		return
+	}
+
+	if err := c.Conn.SetReadDeadline(time.Now().Add(headerReadTimeout)); err != nil {
+		c.err = fmt.Errorf("proxyproto: failed to set header read deadline: %w", err)
+		return
+	}
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	br := bufio.NewReader(c.Conn)
+	addr, err := readHeader(br)
+	if err != nil {
+		if c.mode == ModeRequired {
+			c.err = fmt.Errorf("proxyproto: %w", err)
+			return
+		}
+		// Optional mode: no header present, fall back to the TCP peer.
+		c.br = br
+		return
+	}
+	c.srcAddr = addr
+	c.br = br
+}
+
+// RemoteAddr returns the client address embedded in the PROXY header, if
+// one was parsed, otherwise the underlying TCP peer address.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.srcAddr != nil {
+		return c.srcAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+var errNoHeader = fmt.Errorf("proxyproto: no PROXY header present")
+
+func readHeader(br *bufio.Reader) (net.Addr, error) {
+	peek, err := br.Peek(len(v2Signature))
+	if err == nil && bytes.Equal(peek, v2Signature) {
+		return parseV2(br)
+	}
+	peek, err = br.Peek(6)
+	if err == nil && string(peek) == "PROXY " {
+		return parseV1(br)
+	}
+	return nil, errNoHeader
+}
+
+// parseV1 parses the text PROXY protocol v1 header, e.g.:
+//
+//	PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func parseV1(br *bufio.Reader) (net.Addr, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("v1: failed to read header line: %w", err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, fmt.Errorf("v1: malformed header %q", line)
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("v1: malformed header %q", line)
+	}
+	srcIP := net.ParseIP(fields[2])
+	if srcIP == nil {
+		return nil, fmt.Errorf("v1: invalid source IP %q", fields[2])
+	}
+	srcPort, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return nil, fmt.Errorf("v1: invalid source port %q", fields[4])
+	}
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, nil
+}
+
+// parseV2 parses the binary PROXY protocol v2 header.
+func parseV2(br *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := readFull(br, header); err != nil {
+		return nil, fmt.Errorf("v2: failed to read header: %w", err)
+	}
+
+	verCmd := header[12]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("v2: unsupported version %d", verCmd>>4)
+	}
+	cmd := verCmd & 0x0F
+	famProto := header[13]
+	family := famProto >> 4
+	length := binary.BigEndian.Uint16(header[14:16])
+
+	payload := make([]byte, length)
+	if _, err := readFull(br, payload); err != nil {
+		return nil, fmt.Errorf("v2: failed to read address block: %w", err)
+	}
+
+	if cmd == 0 {
+		// LOCAL command: connection originated from the proxy itself
+		// (e.g. a health check) - no address to extract.
+		return nil, nil
+	}
+
+	switch family {
+	case 1: // AF_INET
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("v2: short IPv4 address block")
+		}
+		ip := net.IP(payload[0:4])
+		port := binary.BigEndian.Uint16(payload[8:10])
+		return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+	case 2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("v2: short IPv6 address block")
+		}
+		ip := net.IP(payload[0:16])
+		port := binary.BigEndian.Uint16(payload[32:34])
+		return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+	default:
+		// AF_UNSPEC or AF_UNIX: nothing usable, keep the TCP peer.
+		return nil, nil
+	}
+}
+
+func readFull(br *bufio.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := br.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+type contextKey int
+
+const trustedPeerKey contextKey = 0
+
+// WithTrustedPeer returns a copy of ctx recording whether the direct TCP
+// peer for this connection is in the trusted-proxies allowlist.
+func WithTrustedPeer(ctx context.Context, trusted bool) context.Context {
+	return context.WithValue(ctx, trustedPeerKey, trusted)
+}
+
+// TrustedPeerFromContext reports whether the direct TCP peer was trusted,
+// as recorded by WithTrustedPeer. It defaults to false (untrusted) when
+// absent, matching the package's deny-by-default posture.
+func TrustedPeerFromContext(ctx context.Context) bool {
+	trusted, _ := ctx.Value(trustedPeerKey).(bool)
+	return trusted
+}
@@ -0,0 +1,273 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeConn is a minimal net.Conn backed by an in-memory buffer, used to
+// feed fixed byte streams through Conn.Read without a real socket.
+type fakeConn struct {
+	r          *bytes.Buffer
+	remoteAddr net.Addr
+}
+
+func (f *fakeConn) Read(b []byte) (int, error)  { return f.r.Read(b) }
+func (f *fakeConn) Write(b []byte) (int, error) { return len(b), nil }
+func (f *fakeConn) Close() error                { return nil }
+func (f *fakeConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+}
+func (f *fakeConn) RemoteAddr() net.Addr               { return f.remoteAddr }
+func (f *fakeConn) SetDeadline(t time.Time) error      { return nil }
+func (f *fakeConn) SetReadDeadline(t time.Time) error  { return nil }
+func (f *fakeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func tcpAddr(ip string, port int) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: port}
+}
+
+func buildV2(family byte, src net.IP, srcPort uint16, dst net.IP, dstPort uint16) []byte {
+	var buf bytes.Buffer
+	buf.Write(v2Signature)
+	buf.WriteByte(0x21) // version 2, command PROXY
+	var addrLen int
+	switch family {
+	case 1:
+		buf.WriteByte(0x11) // AF_INET, STREAM
+		addrLen = 12
+	case 2:
+		buf.WriteByte(0x21) // AF_INET6, STREAM
+		addrLen = 36
+	}
+	lenBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenBuf, uint16(addrLen))
+	buf.Write(lenBuf)
+
+	if family == 1 {
+		buf.Write(src.To4())
+		buf.Write(dst.To4())
+	} else {
+		buf.Write(src.To16())
+		buf.Write(dst.To16())
+	}
+	portBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(portBuf, srcPort)
+	buf.Write(portBuf)
+	binary.BigEndian.PutUint16(portBuf, dstPort)
+	buf.Write(portBuf)
+	return buf.Bytes()
+}
+
+func readAll(t *testing.T, c *Conn) []byte {
+	t.Helper()
+	out, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	return out
+}
+
+func TestConn_ParsesV1Header(t *testing.T) {
+	payload := "PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\nGET / HTTP/1.1\r\n\r\n"
+	inner := &fakeConn{r: bytes.NewBufferString(payload), remoteAddr: tcpAddr("10.0.0.1", 12345)}
+	c := &Conn{Conn: inner, mode: ModeRequired, trustedPeer: true}
+	c.parseHeader()
+
+	body := readAll(t, c)
+	if string(body) != "GET / HTTP/1.1\r\n\r\n" {
+		t.Fatalf("unexpected body after v1 header: %q", body)
+	}
+
+	want := tcpAddr("203.0.113.7", 56324)
+	if c.RemoteAddr().String() != want.String() {
+		t.Fatalf("RemoteAddr() = %s; want %s", c.RemoteAddr(), want)
+	}
+}
+
+func TestConn_ParsesV2HeaderIPv4(t *testing.T) {
+	header := buildV2(1, net.ParseIP("198.51.100.9"), 4321, net.ParseIP("10.0.0.1"), 443)
+	body := []byte("GET /v2/ HTTP/1.1\r\n\r\n")
+	inner := &fakeConn{r: bytes.NewBuffer(append(header, body...)), remoteAddr: tcpAddr("10.0.0.1", 12345)}
+	c := &Conn{Conn: inner, mode: ModeRequired, trustedPeer: true}
+	c.parseHeader()
+
+	got := readAll(t, c)
+	if !bytes.Equal(got, body) {
+		t.Fatalf("unexpected body after v2 header: %q", got)
+	}
+
+	want := tcpAddr("198.51.100.9", 4321)
+	if c.RemoteAddr().String() != want.String() {
+		t.Fatalf("RemoteAddr() = %s; want %s", c.RemoteAddr(), want)
+	}
+}
+
+func TestConn_ParsesV2HeaderIPv6(t *testing.T) {
+	header := buildV2(2, net.ParseIP("2001:db8::1"), 9000, net.ParseIP("2001:db8::2"), 443)
+	body := []byte("PING")
+	inner := &fakeConn{r: bytes.NewBuffer(append(header, body...)), remoteAddr: tcpAddr("10.0.0.1", 12345)}
+	c := &Conn{Conn: inner, mode: ModeRequired, trustedPeer: true}
+	c.parseHeader()
+
+	got := readAll(t, c)
+	if !bytes.Equal(got, body) {
+		t.Fatalf("unexpected body after v2 header: %q", got)
+	}
+
+	want := tcpAddr("2001:db8::1", 9000)
+	if c.RemoteAddr().String() != want.String() {
+		t.Fatalf("RemoteAddr() = %s; want %s", c.RemoteAddr(), want)
+	}
+}
+
+func TestConn_UntrustedPeerRejectedInRequiredMode(t *testing.T) {
+	payload := "PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\nGET / HTTP/1.1\r\n\r\n"
+	inner := &fakeConn{r: bytes.NewBufferString(payload), remoteAddr: tcpAddr("8.8.8.8", 12345)}
+	c := &Conn{Conn: inner, mode: ModeRequired, trustedPeer: false}
+	c.parseHeader()
+
+	_, err := io.ReadAll(c)
+	if err == nil {
+		t.Fatal("expected an error rejecting the untrusted peer, got nil")
+	}
+	// The untrusted peer's address must never be overridden by its header.
+	if c.RemoteAddr().String() != "8.8.8.8:12345" {
+		t.Fatalf("RemoteAddr() = %s; want unchanged peer address", c.RemoteAddr())
+	}
+}
+
+func TestConn_UntrustedPeerIgnoredHeaderInOptionalMode(t *testing.T) {
+	payload := "PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\nGET / HTTP/1.1\r\n\r\n"
+	inner := &fakeConn{r: bytes.NewBufferString(payload), remoteAddr: tcpAddr("8.8.8.8", 12345)}
+	c := &Conn{Conn: inner, mode: ModeOptional, trustedPeer: false}
+	c.parseHeader()
+
+	body := readAll(t, c)
+	if string(body) != payload {
+		t.Fatalf("expected header to be treated as body for an untrusted peer, got %q", body)
+	}
+	if c.RemoteAddr().String() != "8.8.8.8:12345" {
+		t.Fatalf("RemoteAddr() = %s; want unchanged peer address", c.RemoteAddr())
+	}
+}
+
+func TestConn_OptionalModeFallsBackWithoutHeader(t *testing.T) {
+	inner := &fakeConn{r: bytes.NewBufferString("GET / HTTP/1.1\r\n\r\n"), remoteAddr: tcpAddr("10.0.0.1", 12345)}
+	c := &Conn{Conn: inner, mode: ModeOptional, trustedPeer: true}
+	c.parseHeader()
+
+	body := readAll(t, c)
+	if string(body) != "GET / HTTP/1.1\r\n\r\n" {
+		t.Fatalf("unexpected body: %q", body)
+	}
+	if c.RemoteAddr().String() != "10.0.0.1:12345" {
+		t.Fatalf("RemoteAddr() = %s; want unchanged TCP peer", c.RemoteAddr())
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Mode
+		wantErr bool
+	}{
+		{"", ModeOff, false},
+		{"off", ModeOff, false},
+		{"optional", ModeOptional, false},
+		{"required", ModeRequired, false},
+		{"OPTIONAL", ModeOptional, false},
+		{"bogus", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMode(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("ParseMode(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Fatalf("ParseMode(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestListener_HTTPServerSeesProxiedRemoteAddr drives a real http.Server
+// over the Listener and checks http.Request.RemoteAddr, the same value
+// logx and the IP whitelist consult. net/http reads conn.RemoteAddr()
+// before issuing the connection's first Read, so this only passes if the
+// PROXY header is parsed synchronously in Accept rather than lazily.
+func TestListener_HTTPServerSeesProxiedRemoteAddr(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	l, err := NewListener(tcp, ModeRequired, "127.0.0.1/32")
+	if err != nil {
+		t.Fatalf("NewListener: %v", err)
+	}
+	defer l.Close()
+
+	remoteAddrCh := make(chan string, 1)
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			remoteAddrCh <- r.RemoteAddr
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("PROXY TCP4 9.9.9.9 1.1.1.1 12345 443\r\n")); err != nil {
+		t.Fatalf("write PROXY header: %v", err)
+	}
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+
+	select {
+	case remoteAddr := <-remoteAddrCh:
+		host, _, err := net.SplitHostPort(remoteAddr)
+		if err != nil {
+			t.Fatalf("SplitHostPort(%q): %v", remoteAddr, err)
+		}
+		if host != "9.9.9.9" {
+			t.Fatalf("r.RemoteAddr = %q; want host 9.9.9.9 (the PROXY-declared client), not the raw TCP peer", remoteAddr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for handler to observe a request")
+	}
+}
+
+func TestListener_IsTrustedMixedIPv4AndIPv6(t *testing.T) {
+	l := &Listener{}
+	nets, err := parseTrustedProxies("10.0.0.0/8, 2001:db8::/32")
+	if err != nil {
+		t.Fatalf("parseTrustedProxies: %v", err)
+	}
+	l.trusted = nets
+
+	cases := []struct {
+		addr net.Addr
+		want bool
+	}{
+		{tcpAddr("10.1.2.3", 1000), true},
+		{tcpAddr("8.8.8.8", 1000), false},
+		{tcpAddr("2001:db8::42", 1000), true},
+		{tcpAddr("2001:db9::1", 1000), false},
+	}
+	for _, c := range cases {
+		if got := l.isTrusted(c.addr); got != c.want {
+			t.Errorf("isTrusted(%s) = %v; want %v", c.addr, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeRetryInterval is how long WarmCache waits between failed attempts to
+// obtain an initial certificate.
+const acmeRetryInterval = 10 * time.Second
+
+// AcmeManager wraps autocert.Manager with the readiness tracking ecr-proxy
+// needs to keep /readyz honest: the proxy isn't ready to serve TLS until a
+// certificate has actually been obtained (or loaded from cache) once.
+type AcmeManager struct {
+	*autocert.Manager
+
+	mu    sync.RWMutex
+	ready bool
+}
+
+// NewAcmeManager builds an AcmeManager for domains, caching certificates
+// under cacheDir. email is passed to the CA for expiry notices. A non-empty
+// directoryURL overrides the default (production) ACME directory, e.g. to
+// point at Let's Encrypt's staging environment.
+func NewAcmeManager(domains []string, email, cacheDir, directoryURL string) (*AcmeManager, error) {
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("ACME_DOMAINS must specify at least one domain")
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      email,
+		Cache:      autocert.DirCache(cacheDir),
+	}
+	if directoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: directoryURL}
+	}
+
+	return &AcmeManager{Manager: m}, nil
+}
+
+// WarmCache blocks until a certificate for domain has been obtained (or
+// loaded from cache) at least once, retrying every acmeRetryInterval in
+// between. It returns early if ctx is cancelled first.
+func (a *AcmeManager) WarmCache(ctx context.Context, domain string) {
+	for {
+		if _, err := a.Manager.GetCertificate(&tls.ClientHelloInfo{ServerName: domain}); err == nil {
+			a.mu.Lock()
+			a.ready = true
+			a.mu.Unlock()
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(acmeRetryInterval):
+		}
+	}
+}
+
+// Ready reports whether a certificate has been successfully obtained at
+// least once. Until then /readyz should report not-ready.
+func (a *AcmeManager) Ready() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.ready
+}
+
+// ParseDomains splits the comma-separated ACME_DOMAINS config value.
+func ParseDomains(domains string) []string {
+	var out []string
+	for _, d := range strings.Split(domains, ",") {
+		d = strings.TrimSpace(d)
+		if d != "" {
+			out = append(out, d)
+		}
+	}
+	return out
+}
@@ -0,0 +1,103 @@
+// Package tls provides TLS certificate material for the proxy's listener:
+// a zero-config self-signed certificate, a certificate loaded from disk, or
+// one managed automatically via ACME (see acme.go).
+package tls
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Mode selects where the proxy's TLS certificate comes from.
+type Mode string
+
+const (
+	// ModeSelfSigned generates (and caches on disk) a self-signed cert.
+	ModeSelfSigned Mode = "selfsigned"
+	// ModeFile loads a certificate/key pair from disk; the operator is
+	// responsible for provisioning and rotating it.
+	ModeFile Mode = "file"
+	// ModeACME obtains and renews a certificate automatically via the
+	// ACME protocol (e.g. Let's Encrypt).
+	ModeACME Mode = "acme"
+)
+
+// ParseMode validates and normalizes a TLS_MODE config value.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(strings.ToLower(strings.TrimSpace(s))) {
+	case "", ModeSelfSigned:
+		return ModeSelfSigned, nil
+	case ModeFile:
+		return ModeFile, nil
+	case ModeACME:
+		return ModeACME, nil
+	default:
+		return "", fmt.Errorf("invalid TLS_MODE %q: must be selfsigned, file, or acme", s)
+	}
+}
+
+// Generate creates a self-signed TLS certificate/key pair and writes them to
+// certFile and keyFile in PEM format. It is the zero-config fallback used
+// when no certificate is supplied; clients must explicitly trust the
+// resulting certificate.
+func Generate(certFile, keyFile string) error {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   "ecr-proxy",
+			Organization: []string{"ecr-proxy"},
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	certOut, err := os.OpenFile(certFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for writing: %w", certFile, err)
+	}
+	defer certOut.Close()
+	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+
+	keyOut, err := os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for writing: %w", keyFile, err)
+	}
+	defer keyOut.Close()
+	if err := pem.Encode(keyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}); err != nil {
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+
+	return nil
+}
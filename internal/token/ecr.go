@@ -9,6 +9,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/giuliocalzolari/ecr-proxy/internal/metrics"
 )
 
 const (
@@ -101,7 +102,22 @@ func (t *Token) Refresh() error {
 		return nil
 	}
 
-	// Get ECR authorization token
+	start := time.Now()
+	err := t.refreshLocked()
+	metrics.TokenRefreshDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		metrics.TokenRefreshTotal.WithLabelValues("failure").Inc()
+		return err
+	}
+	metrics.TokenRefreshTotal.WithLabelValues("success").Inc()
+	metrics.TokenExpirySeconds.WithLabelValues(t.Account, t.Region).Set(time.Until(t.ExpiresAt).Seconds())
+	return nil
+}
+
+// refreshLocked performs the actual ECR GetAuthorizationToken call and
+// updates t. Callers must hold t.Lock.
+func (t *Token) refreshLocked() error {
 	svc := ecr.New(t.sess)
 	result, err := svc.GetAuthorizationToken(&ecr.GetAuthorizationTokenInput{
 		RegistryIds: []*string{aws.String(t.Account)},
@@ -137,4 +153,4 @@ func (t *Token) Refresh() error {
 	}
 	t.Endpoint = endpoint
 	return nil
-}
\ No newline at end of file
+}
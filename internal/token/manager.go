@@ -0,0 +1,135 @@
+package token
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// key identifies one managed ECR account/region pair.
+type key struct {
+	Account string
+	Region  string
+}
+
+func (k key) String() string {
+	return k.Account + "@" + k.Region
+}
+
+// Manager holds a Token per ECR account/region, initializing and refreshing
+// each independently. This lets a single proxy serve multiple AWS accounts
+// or regions instead of being bound to one at startup.
+//
+// Manager only ever mints tokens for account/region pairs the operator
+// configured via Add; Get rejects anything else so AWS_ACCOUNTS acts as an
+// allowlist rather than a set of pre-warmed entries a client can bypass.
+type Manager struct {
+	mu      sync.RWMutex
+	tokens  map[key]*Token
+	allowed map[key]struct{}
+}
+
+// NewManager creates an empty Manager.
+func NewManager() *Manager {
+	return &Manager{tokens: make(map[key]*Token), allowed: make(map[key]struct{})}
+}
+
+// ErrAccountNotConfigured is returned by Get when the requested
+// account/region isn't one of the operator-configured AWS_ACCOUNTS entries.
+var ErrAccountNotConfigured = fmt.Errorf("account/region is not configured")
+
+// Spec identifies one account/region a Manager should serve.
+type Spec struct {
+	Account string
+	Region  string
+}
+
+// ParseAccounts parses a comma-separated AWS_ACCOUNTS value of
+// "account@region" entries, e.g. "111111111111@us-east-1,222222222222@eu-west-1".
+func ParseAccounts(accounts string) ([]Spec, error) {
+	var specs []Spec
+	for _, entry := range strings.Split(accounts, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "@", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid AWS_ACCOUNTS entry %q: expected account@region", entry)
+		}
+		specs = append(specs, Spec{Account: parts[0], Region: parts[1]})
+	}
+	return specs, nil
+}
+
+// Add registers account/region as an allowed entry and eagerly initializes
+// its Token, returning an error if the initial token fetch fails.
+func (m *Manager) Add(account, region string) error {
+	k := key{Account: account, Region: region}
+	t, err := NewToken(region, account)
+	if err != nil {
+		return fmt.Errorf("failed to initialize token for %s: %w", k, err)
+	}
+	m.mu.Lock()
+	m.allowed[k] = struct{}{}
+	m.tokens[k] = t
+	m.mu.Unlock()
+	return nil
+}
+
+// Get returns the Token for account/region, lazily initializing one on
+// first use if it's a configured entry that hasn't been initialized yet.
+// account/region pairs outside the configured allowlist are rejected with
+// ErrAccountNotConfigured, regardless of what the proxy's AWS credentials
+// could otherwise reach.
+func (m *Manager) Get(account, region string) (*Token, error) {
+	k := key{Account: account, Region: region}
+
+	m.mu.RLock()
+	t, ok := m.tokens[k]
+	_, allowed := m.allowed[k]
+	m.mu.RUnlock()
+	if ok {
+		return t, nil
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%s: %w", k, ErrAccountNotConfigured)
+	}
+
+	t, err := NewToken(region, account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize token for %s: %w", k, err)
+	}
+
+	m.mu.Lock()
+	m.tokens[k] = t
+	m.mu.Unlock()
+	return t, nil
+}
+
+// Len reports how many account/region tokens are currently managed.
+func (m *Manager) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.tokens)
+}
+
+// RefreshAll refreshes every managed token independently, so a failure
+// refreshing one account/region doesn't block the others. It returns the
+// error for each token that failed to refresh, keyed by "account@region".
+func (m *Manager) RefreshAll() map[string]error {
+	m.mu.RLock()
+	tokens := make([]*Token, 0, len(m.tokens))
+	for _, t := range m.tokens {
+		tokens = append(tokens, t)
+	}
+	m.mu.RUnlock()
+
+	errs := make(map[string]error)
+	for _, t := range tokens {
+		if err := t.Refresh(); err != nil {
+			errs[key{Account: t.Account, Region: t.Region}.String()] = err
+		}
+	}
+	return errs
+}
@@ -3,9 +3,31 @@ package utils
 import (
 	"log"
 	"net"
+	"net/http"
 	"strings"
+
+	"github.com/giuliocalzolari/ecr-proxy/internal/metrics"
 )
 
+// cidrEntry pairs a parsed subnet with the whitelist entry it came from, so
+// a match can be reported back as a bounded-cardinality metrics label.
+type cidrEntry struct {
+	net   *net.IPNet
+	label string
+}
+
+// GetClientIP extracts the client IP from r.RemoteAddr, stripping the port.
+// r.RemoteAddr reflects whatever the underlying net.Conn reported, so when
+// the listener has resolved a PROXY protocol header this already is the
+// real client address rather than the intermediary's.
+func GetClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return strings.TrimSpace(r.RemoteAddr)
+	}
+	return host
+}
+
 // IsIPAllowed checks if the given remoteAddr IP is allowed based on the ipWhitelist.
 // remoteAddr should be in the format "IP:port" or just "IP".
 // ipWhitelist is a comma-separated list of IPs or CIDR ranges.
@@ -14,12 +36,13 @@ func IsIPAllowed(remoteAddr, ipWhitelist string) bool {
 	// Handle empty whitelist - deny all by default
 	if strings.TrimSpace(ipWhitelist) == "" {
 		log.Printf("Empty whitelist - denying access")
+		metrics.IPWhitelistDecisions.WithLabelValues("deny", "none").Inc()
 		return false
 	}
 
 	// Split the whitelist into individual CIDRs or IPs
 	whitelist := strings.Split(ipWhitelist, ",")
-	var ipNets []*net.IPNet
+	var entries []cidrEntry
 
 	for _, entry := range whitelist {
 		entry = strings.TrimSpace(entry)
@@ -41,20 +64,20 @@ func IsIPAllowed(remoteAddr, ipWhitelist string) bool {
 			log.Printf("Warning: invalid whitelist entry '%s': %v", entry, err)
 			continue
 		}
-		ipNets = append(ipNets, ipnet)
+		entries = append(entries, cidrEntry{net: ipnet, label: entry})
 	}
 
 	// Extract the IP from the remote address using SplitHostPort
-	ipStr := remoteAddr
 	host, _, err := net.SplitHostPort(remoteAddr)
 	if err != nil {
 		// Might be IP without port, try parsing directly
 		host = strings.TrimSpace(remoteAddr)
 	}
-	
+
 	ip := net.ParseIP(strings.TrimSpace(host))
 	if ip == nil {
 		log.Printf("Failed to parse IP from remoteAddr: %s", remoteAddr)
+		metrics.IPWhitelistDecisions.WithLabelValues("deny", "none").Inc()
 		return false
 	}
 
@@ -64,12 +87,14 @@ func IsIPAllowed(remoteAddr, ipWhitelist string) bool {
 	}
 
 	// Check if the IP is in any of the allowed subnets
-	for _, ipnet := range ipNets {
-		if ipnet.Contains(ip) {
+	for _, e := range entries {
+		if e.net.Contains(ip) {
+			metrics.IPWhitelistDecisions.WithLabelValues("allow", e.label).Inc()
 			return true
 		}
 	}
 
 	log.Printf("Access denied for IP (not in whitelist)")
+	metrics.IPWhitelistDecisions.WithLabelValues("deny", "none").Inc()
 	return false
-}
\ No newline at end of file
+}
@@ -2,14 +2,19 @@ package main
 
 import (
 	"context"
+	gotls "crypto/tls"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"os/signal"
+	"os/user"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -17,7 +22,11 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/coreos/go-systemd/activation"
+	"github.com/giuliocalzolari/ecr-proxy/internal/auth"
 	"github.com/giuliocalzolari/ecr-proxy/internal/logx"
+	"github.com/giuliocalzolari/ecr-proxy/internal/metrics"
+	"github.com/giuliocalzolari/ecr-proxy/internal/proxyproto"
 	"github.com/giuliocalzolari/ecr-proxy/internal/tls"
 	"github.com/giuliocalzolari/ecr-proxy/internal/token"
 	"github.com/giuliocalzolari/ecr-proxy/internal/utils"
@@ -30,21 +39,69 @@ const (
 	v2Path            = "/v2/"
 )
 
+// version and commit are set via -ldflags at build time, e.g.:
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse HEAD)"
+var (
+	version = "dev"
+	commit  = "none"
+)
+
 type config struct {
-	Region      string `env:"AWS_REGION, default=us-east-1"`
-	Account     string `env:"AWS_ACCOUNT_ID"`
-	IpWhitelist string `env:"IP_WHITELIST, default="`
-	TlsCertFile string `env:"TLS_CERT_FILE, default=./certs/tls.crt"`
-	TlsKeyFile  string `env:"TLS_KEY_FILE, default=./certs/tls.key"`
-	Port        string `env:"PORT, default=5000"`
+	Region            string `env:"AWS_REGION, default=us-east-1"`
+	Account           string `env:"AWS_ACCOUNT_ID"`
+	IpWhitelist       string `env:"IP_WHITELIST, default="`
+	TlsMode           string `env:"TLS_MODE, default=selfsigned"`
+	TlsCertFile       string `env:"TLS_CERT_FILE, default=./certs/tls.crt"`
+	TlsKeyFile        string `env:"TLS_KEY_FILE, default=./certs/tls.key"`
+	AcmeDomains       string `env:"ACME_DOMAINS, default="`
+	AcmeEmail         string `env:"ACME_EMAIL, default="`
+	AcmeCacheDir      string `env:"ACME_CACHE_DIR, default=./acme-cache"`
+	AcmeDirectoryURL  string `env:"ACME_DIRECTORY_URL, default="`
+	AcmeHTTPPort      string `env:"ACME_HTTP_PORT, default=80"`
+	Port              string `env:"PORT, default=5000"`
+	ProxyProtocol     string `env:"PROXY_PROTOCOL, default=off"`
+	TrustedProxies    string `env:"TRUSTED_PROXIES, default="`
+	AwsAccounts       string `env:"AWS_ACCOUNTS, default="`
+	MetricsListen     string `env:"METRICS_LISTEN, default="`
+	Auth              string `env:"AUTH, default="`
+	ListenSocket      string `env:"LISTEN_SOCKET, default="`
+	ListenSocketMode  string `env:"LISTEN_SOCKET_MODE, default=0660"`
+	ListenSocketGroup string `env:"LISTEN_SOCKET_GROUP, default="`
+}
+
+// ecrAccountHeader lets clients select a non-default account/region by
+// setting e.g. "X-ECR-Account: 111111111111@us-east-1".
+const ecrAccountHeader = "X-ECR-Account"
+
+// unixSocketCtxKey marks a request as having arrived over LISTEN_SOCKET
+// rather than the public TCP listener.
+type unixSocketCtxKey struct{}
+
+// withUnixSocket returns a copy of ctx recording that the connection it's
+// attached to came in over the unix domain socket listener.
+func withUnixSocket(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unixSocketCtxKey{}, true)
+}
+
+// isUnixSocketRequest reports whether r arrived over LISTEN_SOCKET. A unix
+// socket has no TCP peer address, so http.Request.RemoteAddr is "@" on it -
+// net.SplitHostPort/net.ParseIP can't make sense of that, and the IP
+// whitelist isn't meaningful there anyway; access is controlled by
+// filesystem permissions on the socket instead.
+func isUnixSocketRequest(r *http.Request) bool {
+	unix, _ := r.Context().Value(unixSocketCtxKey{}).(bool)
+	return unix
 }
 
 type proxyServer struct {
-	token       *token.Token
-	tokenMux    sync.RWMutex
+	tokens      *token.Manager
 	cfg         config
-	proxy       *httputil.ReverseProxy
+	proxyMux    sync.RWMutex
+	proxies     map[string]*httputil.ReverseProxy
 	httpServer  *http.Server
+	acmeManager *tls.AcmeManager
+	auth        auth.Authenticator
 }
 
 func main() {
@@ -60,43 +117,213 @@ func main() {
 		log.Fatalf("Invalid configuration: %v", err)
 	}
 
-	t, err := initializeToken(cfg)
+	tokens, err := initializeTokenManager(cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize token: %v", err)
+		log.Fatalf("Failed to initialize token manager: %v", err)
 	}
 
-	server := &proxyServer{
-		token: t,
-		cfg:   cfg,
+	authenticator, err := auth.New(cfg.Auth)
+	if err != nil {
+		log.Fatalf("Failed to configure AUTH: %v", err)
 	}
 
-	if err := server.setupProxy(); err != nil {
-		log.Fatalf("Failed to setup proxy: %v", err)
+	server := &proxyServer{
+		tokens:  tokens,
+		cfg:     cfg,
+		proxies: make(map[string]*httputil.ReverseProxy),
+		auth:    authenticator,
 	}
 
 	if err := ensureTLSCertificates(cfg); err != nil {
 		log.Fatalf("Failed to setup TLS certificates: %v", err)
 	}
 
+	metrics.BuildInfo.WithLabelValues(version, commit).Set(1)
+
 	server.setupRoutes()
 
 	go server.refreshTokenPeriodically(ctx)
 
+	proxyProtoMode, err := proxyproto.ParseMode(cfg.ProxyProtocol)
+	if err != nil {
+		log.Fatalf("Invalid proxy protocol mode: %v", err)
+	}
+
 	server.httpServer = &http.Server{
 		Addr:         ":" + cfg.Port,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  120 * time.Second,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			// Both TLS serving paths hand ConnContext the *tls.Conn that
+			// wraps the accepted connection, so look through it.
+			if tc, ok := c.(*gotls.Conn); ok {
+				c = tc.NetConn()
+			}
+			if pc, ok := c.(*proxyproto.Conn); ok {
+				ctx = proxyproto.WithTrustedPeer(ctx, pc.Trusted())
+			}
+			return ctx
+		},
 	}
 
-	go func() {
-		log.Printf("Starting HTTPS ECR proxy on port %s for %s", cfg.Port, t.GetEndpoint())
-		if err := server.httpServer.ListenAndServeTLS(cfg.TlsCertFile, cfg.TlsKeyFile); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed: %v", err)
+	listener, err := net.Listen("tcp", server.httpServer.Addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", server.httpServer.Addr, err)
+	}
+	if proxyProtoMode != proxyproto.ModeOff || cfg.TrustedProxies != "" {
+		listener, err = proxyproto.NewListener(listener, proxyProtoMode, cfg.TrustedProxies)
+		if err != nil {
+			log.Fatalf("Failed to configure PROXY protocol listener: %v", err)
 		}
-	}()
+	}
+
+	tlsMode, err := tls.ParseMode(cfg.TlsMode)
+	if err != nil {
+		log.Fatalf("Invalid TLS mode: %v", err)
+	}
 
-	handleGracefulShutdown(ctx, cancel, server.httpServer)
+	if tlsMode == tls.ModeACME {
+		domains := tls.ParseDomains(cfg.AcmeDomains)
+		acmeManager, err := tls.NewAcmeManager(domains, cfg.AcmeEmail, cfg.AcmeCacheDir, cfg.AcmeDirectoryURL)
+		if err != nil {
+			log.Fatalf("Failed to configure ACME: %v", err)
+		}
+		server.acmeManager = acmeManager
+		server.httpServer.TLSConfig = acmeManager.TLSConfig()
+		applyAuthTLSConfig(server.auth, server.httpServer.TLSConfig)
+
+		go acmeManager.WarmCache(ctx, domains[0])
+		go func() {
+			log.Printf("Serving ACME HTTP-01 challenges on port %s", cfg.AcmeHTTPPort)
+			if err := http.ListenAndServe(":"+cfg.AcmeHTTPPort, acmeManager.HTTPHandler(nil)); err != nil {
+				log.Printf("ACME HTTP-01 challenge server stopped: %v", err)
+			}
+		}()
+
+		go func() {
+			log.Printf("Starting HTTPS ECR proxy on port %s for %d account(s) (ACME: %v)", cfg.Port, tokens.Len(), domains)
+			if err := server.httpServer.Serve(gotls.NewListener(listener, server.httpServer.TLSConfig)); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Server failed: %v", err)
+			}
+		}()
+	} else {
+		if _, ok := server.auth.(auth.TLSConfigurer); ok {
+			server.httpServer.TLSConfig = &gotls.Config{}
+			applyAuthTLSConfig(server.auth, server.httpServer.TLSConfig)
+		}
+		go func() {
+			log.Printf("Starting HTTPS ECR proxy on port %s for %d account(s)", cfg.Port, tokens.Len())
+			if err := server.httpServer.ServeTLS(listener, cfg.TlsCertFile, cfg.TlsKeyFile); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Server failed: %v", err)
+			}
+		}()
+	}
+
+	var unixServer *http.Server
+	var unixSocketPath string
+	if cfg.ListenSocket != "" {
+		unixListener, fromSystemd, err := newUnixListener(cfg)
+		if err != nil {
+			log.Fatalf("Failed to set up LISTEN_SOCKET: %v", err)
+		}
+		if !fromSystemd {
+			unixSocketPath = cfg.ListenSocket
+		}
+
+		unixServer = &http.Server{
+			ReadTimeout:  30 * time.Second,
+			WriteTimeout: 30 * time.Second,
+			IdleTimeout:  120 * time.Second,
+			ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+				return withUnixSocket(ctx)
+			},
+		}
+		go func() {
+			log.Printf("Starting HTTP ECR proxy on unix socket %s", cfg.ListenSocket)
+			if err := unixServer.Serve(unixListener); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Unix socket server failed: %v", err)
+			}
+		}()
+	}
+
+	handleGracefulShutdown(ctx, cancel, server.httpServer, unixServer, unixSocketPath)
+}
+
+// newUnixListener builds the net.Listener for LISTEN_SOCKET. If the process
+// was started via systemd socket activation (LISTEN_FDS), the supervisor's
+// socket is reused as-is; otherwise a fresh socket is created at
+// cfg.ListenSocket, replacing any stale file left behind by a previous run
+// and applying LISTEN_SOCKET_MODE/LISTEN_SOCKET_GROUP. The returned bool
+// reports whether the listener came from systemd activation, so the caller
+// knows not to remove the socket file on shutdown.
+func newUnixListener(cfg config) (net.Listener, bool, error) {
+	if l, err := systemdUnixListener(cfg.ListenSocket); err != nil {
+		return nil, false, err
+	} else if l != nil {
+		return l, true, nil
+	}
+
+	if err := os.Remove(cfg.ListenSocket); err != nil && !os.IsNotExist(err) {
+		return nil, false, fmt.Errorf("failed to remove stale socket %s: %w", cfg.ListenSocket, err)
+	}
+
+	listener, err := net.Listen("unix", cfg.ListenSocket)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to listen on unix socket %s: %w", cfg.ListenSocket, err)
+	}
+
+	mode, err := strconv.ParseUint(cfg.ListenSocketMode, 8, 32)
+	if err != nil {
+		listener.Close()
+		return nil, false, fmt.Errorf("invalid LISTEN_SOCKET_MODE %q: %w", cfg.ListenSocketMode, err)
+	}
+	if err := os.Chmod(cfg.ListenSocket, os.FileMode(mode)); err != nil {
+		listener.Close()
+		return nil, false, fmt.Errorf("failed to chmod socket %s: %w", cfg.ListenSocket, err)
+	}
+
+	if cfg.ListenSocketGroup != "" {
+		if err := chownSocketGroup(cfg.ListenSocket, cfg.ListenSocketGroup); err != nil {
+			listener.Close()
+			return nil, false, err
+		}
+	}
+
+	return listener, false, nil
+}
+
+// systemdUnixListener returns the systemd-activated listener for path, if
+// the process was started with a matching socket passed in via LISTEN_FDS,
+// or nil if it wasn't.
+func systemdUnixListener(path string) (net.Listener, error) {
+	listeners, err := activation.Listeners()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect systemd socket activation: %w", err)
+	}
+	for _, l := range listeners {
+		if l.Addr().Network() == "unix" && l.Addr().String() == path {
+			return l, nil
+		}
+	}
+	return nil, nil
+}
+
+// chownSocketGroup changes the group ownership of the unix socket at path
+// to group, leaving its owning user unchanged.
+func chownSocketGroup(path, group string) error {
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return fmt.Errorf("failed to look up LISTEN_SOCKET_GROUP %q: %w", group, err)
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return fmt.Errorf("invalid gid %q for group %q: %w", g.Gid, group, err)
+	}
+	if err := os.Chown(path, -1, gid); err != nil {
+		return fmt.Errorf("failed to chown socket %s to group %s: %w", path, group, err)
+	}
+	return nil
 }
 
 func loadConfig(ctx context.Context) (config, error) {
@@ -122,6 +349,32 @@ func validateConfig(cfg config) error {
 	if err != nil || port < 1 || port > 65535 {
 		return fmt.Errorf("invalid port number: %s", cfg.Port)
 	}
+	if _, err := proxyproto.ParseMode(cfg.ProxyProtocol); err != nil {
+		return err
+	}
+
+	mode, err := tls.ParseMode(cfg.TlsMode)
+	if err != nil {
+		return err
+	}
+	if mode == tls.ModeACME && len(tls.ParseDomains(cfg.AcmeDomains)) == 0 {
+		return fmt.Errorf("ACME_DOMAINS is required when TLS_MODE=acme")
+	}
+
+	if _, err := token.ParseAccounts(cfg.AwsAccounts); err != nil {
+		return err
+	}
+
+	if _, err := auth.New(cfg.Auth); err != nil {
+		return err
+	}
+
+	if cfg.ListenSocket != "" {
+		if _, err := strconv.ParseUint(cfg.ListenSocketMode, 8, 32); err != nil {
+			return fmt.Errorf("invalid LISTEN_SOCKET_MODE %q: %w", cfg.ListenSocketMode, err)
+		}
+	}
+
 	return nil
 }
 
@@ -140,72 +393,261 @@ func getAWSAccountID(region string) (string, error) {
 	return *idResp.Account, nil
 }
 
-func initializeToken(cfg config) (*token.Token, error) {
-	t := token.NewToken(cfg.Region, cfg.Account)
-	if !t.IsValid() {
-		return nil, fmt.Errorf("failed to initialize valid ECR token")
+// initializeTokenManager builds the token.Manager for every account/region
+// ecr-proxy should serve. AWS_ACCOUNTS, when set, lists the "account@region"
+// entries to manage instead of the default AWS_ACCOUNT_ID/AWS_REGION pair;
+// that pair is managed only when AWS_ACCOUNTS is empty or lists it.
+func initializeTokenManager(cfg config) (*token.Manager, error) {
+	specs, err := token.ParseAccounts(cfg.AwsAccounts)
+	if err != nil {
+		return nil, err
 	}
-	return t, nil
+	if len(specs) == 0 {
+		specs = []token.Spec{{Account: cfg.Account, Region: cfg.Region}}
+	}
+
+	m := token.NewManager()
+	for _, spec := range specs {
+		if err := m.Add(spec.Account, spec.Region); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
 }
 
-func (s *proxyServer) setupProxy() error {
-	target, err := url.Parse("https://" + s.token.GetEndpoint())
-	if err != nil {
-		return fmt.Errorf("failed to parse target URL: %w", err)
+// selectAccount determines which account/region a request targets, in
+// priority order: a "/v2/<account>@<region>/..." path prefix (which is then
+// stripped before proxying), the X-ECR-Account header, or the configured
+// default. It mutates req.URL.Path when a routing prefix is consumed.
+func (s *proxyServer) selectAccount(req *http.Request) (account, region string) {
+	account, region = s.cfg.Account, s.cfg.Region
+
+	if rest := strings.TrimPrefix(req.URL.Path, v2Path); rest != req.URL.Path {
+		if idx := strings.Index(rest, "/"); idx > 0 {
+			if a, r, ok := parseAccountRegion(rest[:idx]); ok {
+				account, region = a, r
+				req.URL.Path = v2Path + rest[idx+1:]
+				return account, region
+			}
+		}
+	}
+
+	if h := req.Header.Get(ecrAccountHeader); h != "" {
+		if a, r, ok := parseAccountRegion(h); ok {
+			account, region = a, r
+		}
+	}
+
+	return account, region
+}
+
+// parseAccountRegion splits an "account@region" routing token.
+func parseAccountRegion(s string) (account, region string, ok bool) {
+	parts := strings.SplitN(s, "@", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// proxyFor returns the cached reverse proxy for account/region, building
+// and caching one on first use.
+func (s *proxyServer) proxyFor(account, region string, t *token.Token) (*httputil.ReverseProxy, error) {
+	routeKey := account + "@" + region
+
+	s.proxyMux.RLock()
+	p, ok := s.proxies[routeKey]
+	s.proxyMux.RUnlock()
+	if ok {
+		return p, nil
 	}
 
-	s.proxy = httputil.NewSingleHostReverseProxy(target)
-	s.proxy.Director = func(req *http.Request) {
-		s.tokenMux.RLock()
-		endpoint := s.token.GetEndpoint()
-		authToken := s.token.GetToken()
-		s.tokenMux.RUnlock()
+	s.proxyMux.Lock()
+	defer s.proxyMux.Unlock()
+	if p, ok := s.proxies[routeKey]; ok {
+		return p, nil
+	}
+
+	target, err := url.Parse("https://" + t.GetEndpoint())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse target URL for %s: %w", routeKey, err)
+	}
 
+	p = httputil.NewSingleHostReverseProxy(target)
+	p.Transport = metrics.WrapTransport(nil)
+	p.Director = func(req *http.Request) {
+		endpoint := t.GetEndpoint()
 		req.URL.Scheme = "https"
 		req.URL.Host = endpoint
 		req.Host = endpoint
-		req.Header.Set("Authorization", "Basic "+authToken)
+		if authToken, err := t.GetToken(); err == nil {
+			req.Header.Set("Authorization", "Basic "+authToken)
+		}
 	}
 
-	return nil
+	s.proxies[routeKey] = p
+	return p, nil
+}
+
+// applyAuthTLSConfig wires authenticator into tlsConfig when it implements
+// auth.TLSConfigurer (currently only AUTH=mtls://...), so the TLS handshake
+// itself enforces client certificate verification.
+func applyAuthTLSConfig(authenticator auth.Authenticator, tlsConfig *gotls.Config) {
+	if tc, ok := authenticator.(auth.TLSConfigurer); ok {
+		tc.ConfigureTLS(tlsConfig)
+	}
 }
 
 func ensureTLSCertificates(cfg config) error {
-	if _, err := os.Stat(cfg.TlsCertFile); os.IsNotExist(err) {
-		log.Printf("TLS certificate not found, generating self-signed certificate at %s", cfg.TlsCertFile)
-		if err := os.MkdirAll("./certs", 0700); err != nil {
-			return fmt.Errorf("failed to create certs directory: %w", err)
+	mode, err := tls.ParseMode(cfg.TlsMode)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case tls.ModeACME:
+		// Certificates are obtained on demand by the ACME manager; there's
+		// nothing to provision up front.
+		return nil
+	case tls.ModeFile:
+		if _, err := os.Stat(cfg.TlsCertFile); os.IsNotExist(err) {
+			return fmt.Errorf("TLS_MODE=file but certificate %s does not exist", cfg.TlsCertFile)
 		}
-		if err := tls.Generate(cfg.TlsCertFile, cfg.TlsKeyFile); err != nil {
-			return fmt.Errorf("failed to generate TLS certificates: %w", err)
+		if _, err := os.Stat(cfg.TlsKeyFile); os.IsNotExist(err) {
+			return fmt.Errorf("TLS_MODE=file but key %s does not exist", cfg.TlsKeyFile)
 		}
-		log.Printf("WARNING: Using self-signed certificate. Clients must trust this certificate.")
+		return nil
+	default: // tls.ModeSelfSigned
+		if _, err := os.Stat(cfg.TlsCertFile); os.IsNotExist(err) {
+			log.Printf("TLS certificate not found, generating self-signed certificate at %s", cfg.TlsCertFile)
+			if err := os.MkdirAll("./certs", 0700); err != nil {
+				return fmt.Errorf("failed to create certs directory: %w", err)
+			}
+			if err := tls.Generate(cfg.TlsCertFile, cfg.TlsKeyFile); err != nil {
+				return fmt.Errorf("failed to generate TLS certificates: %w", err)
+			}
+			log.Printf("WARNING: Using self-signed certificate. Clients must trust this certificate.")
+		}
+		return nil
 	}
-	return nil
 }
 
 func (s *proxyServer) setupRoutes() {
 	http.HandleFunc(v2Path, s.handleProxy)
 	http.HandleFunc("/healthz", s.handleHealthz)
 	http.HandleFunc("/readyz", s.handleReadyz)
+
+	if s.cfg.MetricsListen == "" {
+		// No dedicated METRICS_LISTEN address: /metrics shares the public
+		// listener with /v2/, so it must pass through the same IP
+		// whitelist/auth gate rather than being exposed to anyone who can
+		// reach the port.
+		http.HandleFunc("/metrics", s.handleMetrics)
+		return
+	}
+
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", metrics.Handler())
+	go func() {
+		log.Printf("Starting metrics server on %s", s.cfg.MetricsListen)
+		if err := http.ListenAndServe(s.cfg.MetricsListen, metricsMux); err != nil {
+			log.Printf("Metrics server stopped: %v", err)
+		}
+	}()
 }
 
-func (s *proxyServer) handleProxy(w http.ResponseWriter, r *http.Request) {
-	if s.cfg.IpWhitelist != "" {
+func (s *proxyServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.checkAccess(w, r); !ok {
+		return
+	}
+	metrics.Handler().ServeHTTP(w, r)
+}
+
+// checkAccess applies the IP whitelist and authenticator gates shared by
+// every handler exposed on the public listener. It writes an error
+// response and returns ok=false if the request is denied; callers must
+// stop handling the request in that case. On success it returns the
+// (possibly auth-subject-annotated) request to continue with.
+func (s *proxyServer) checkAccess(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if s.cfg.IpWhitelist != "" && !isUnixSocketRequest(r) {
 		clientIP := utils.GetClientIP(r)
-		allowed := utils.IsIPAllowed(clientIP, s.cfg.IpWhitelist)
-		if !allowed {
+		if !utils.IsIPAllowed(clientIP, s.cfg.IpWhitelist) {
 			logx.Print(r, "Denied request from IP (not in whitelist)")
 			http.Error(w, "Forbidden", http.StatusForbidden)
+			return r, false
+		}
+	}
+
+	if s.auth != nil {
+		subject, err := s.auth.Authenticate(r)
+		if err != nil {
+			if challenge := s.auth.Challenge(); challenge != "" {
+				w.Header().Set("WWW-Authenticate", challenge)
+			}
+			logx.Print(r, fmt.Sprintf("Authentication failed: %v", err))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return r, false
+		}
+		r = r.WithContext(auth.WithSubject(r.Context(), subject))
+	}
+
+	return r, true
+}
+
+// statusRecorder captures the response status code an http.Handler wrote,
+// so it can be reported as a metrics label after ServeHTTP returns.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (s *proxyServer) handleProxy(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+	var ok bool
+	r, ok = s.checkAccess(rec, r)
+	if !ok {
+		return
+	}
+
+	account, region := s.selectAccount(r)
+	defer func() {
+		status := strconv.Itoa(rec.status)
+		metrics.ProxyRequestsTotal.WithLabelValues(r.Method, status, account, region).Inc()
+		metrics.ProxyRequestDuration.WithLabelValues(r.Method, status).Observe(time.Since(start).Seconds())
+	}()
+
+	t, err := s.tokens.Get(account, region)
+	if err != nil {
+		logx.Print(r, fmt.Sprintf("Failed to resolve ECR account %s@%s: %v", account, region, err))
+		if errors.Is(err, token.ErrAccountNotConfigured) {
+			// Keep client-chosen, unconfigured values out of the metric labels.
+			account, region = "none", "none"
+			http.Error(rec, "Not Found", http.StatusNotFound)
 			return
 		}
+		http.Error(rec, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+
+	proxy, err := s.proxyFor(account, region, t)
+	if err != nil {
+		logx.Print(r, fmt.Sprintf("Failed to build proxy for %s@%s: %v", account, region, err))
+		http.Error(rec, "Bad Gateway", http.StatusBadGateway)
+		return
 	}
 
 	if r.URL.Path != v2Path {
-		logx.Print(r, "proxy to ECR")
+		logx.Print(r, fmt.Sprintf("proxy to ECR (%s@%s)", account, region))
 	}
 
-	s.proxy.ServeHTTP(w, r)
+	proxy.ServeHTTP(rec, r)
 }
 
 func (s *proxyServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
@@ -213,16 +655,20 @@ func (s *proxyServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *proxyServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
-	s.tokenMux.RLock()
-	valid := s.token.IsValid()
-	s.tokenMux.RUnlock()
-
-	if valid {
-		w.WriteHeader(http.StatusOK)
-	} else {
+	t, err := s.tokens.Get(s.cfg.Account, s.cfg.Region)
+	if err != nil || !t.IsValid() {
 		w.WriteHeader(http.StatusServiceUnavailable)
 		w.Write([]byte("ECR token is not valid or expired"))
+		return
 	}
+
+	if s.acmeManager != nil && !s.acmeManager.Ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("ACME certificate not yet issued"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
 
 func (s *proxyServer) refreshTokenPeriodically(ctx context.Context) {
@@ -234,21 +680,23 @@ func (s *proxyServer) refreshTokenPeriodically(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			log.Println("Refreshing ECR token...")
-			newToken := token.NewToken(s.cfg.Region, s.cfg.Account)
-			if newToken.IsValid() {
-				s.tokenMux.Lock()
-				s.token = newToken
-				s.tokenMux.Unlock()
-				log.Println("ECR token refreshed successfully")
+			log.Println("Refreshing ECR tokens...")
+			if errs := s.tokens.RefreshAll(); len(errs) > 0 {
+				for account, err := range errs {
+					log.Printf("Failed to refresh ECR token for %s: %v", account, err)
+				}
 			} else {
-				log.Println("Failed to refresh ECR token")
+				log.Println("ECR tokens refreshed successfully")
 			}
 		}
 	}
 }
 
-func handleGracefulShutdown(ctx context.Context, cancel context.CancelFunc, server *http.Server) {
+// handleGracefulShutdown blocks until an interrupt/SIGTERM is received, then
+// shuts down server and, if set, unixServer. socketPath is removed
+// afterwards unless empty (it is, when the unix socket came from systemd
+// socket activation, which owns the file).
+func handleGracefulShutdown(ctx context.Context, cancel context.CancelFunc, server *http.Server, unixServer *http.Server, socketPath string) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
@@ -265,4 +713,17 @@ func handleGracefulShutdown(ctx context.Context, cancel context.CancelFunc, serv
 	} else {
 		log.Println("Server stopped gracefully")
 	}
-}
\ No newline at end of file
+
+	if unixServer != nil {
+		if err := unixServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Unix socket server shutdown error: %v", err)
+		} else {
+			log.Println("Unix socket server stopped gracefully")
+		}
+		if socketPath != "" {
+			if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove socket %s: %v", socketPath, err)
+			}
+		}
+	}
+}